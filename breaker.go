@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker trips after a run of consecutive failures, refuses further
+// probes for a cooldown window, then allows a single half-open probe
+// through before deciding whether to close again or reopen.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	state            breakerState
+	failureThreshold int
+	cooldown         time.Duration
+	openedAt         time.Time
+}
+
+// NewCircuitBreaker builds a breaker that opens after failureThreshold
+// consecutive failures and stays open for cooldown.
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 3
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &CircuitBreaker{failureThreshold: failureThreshold, cooldown: cooldown}
+}
+
+// Allow reports whether a probe may proceed. An open breaker whose cooldown
+// has elapsed transitions to half-open and allows exactly the caller's
+// probe through.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+	}
+	return true
+}
+
+// RecordResult feeds a probe's outcome back into the breaker. success
+// closes the breaker unconditionally; a failure reopens it if the breaker
+// was half-open, or if consecutiveFails has now reached the threshold.
+func (b *CircuitBreaker) RecordResult(success bool, consecutiveFails int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = breakerClosed
+		return
+	}
+
+	if b.state == breakerHalfOpen || consecutiveFails >= b.failureThreshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the breaker's current state as a status-endpoint-friendly
+// string.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
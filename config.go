@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultCheckInterval is used when check_interval is unset or invalid.
+const defaultCheckInterval = 5 * time.Second
+
+// UpstreamConfig describes a single backend in the pool, as loaded from
+// config.yml.
+type UpstreamConfig struct {
+	Name                  string `yaml:"name"`
+	Group                 string `yaml:"group"`
+	URL                   string `yaml:"url"`
+	Weight                int    `yaml:"weight"`
+	Timeout               string `yaml:"timeout"`
+	TLSCAFile             string `yaml:"tls_ca_file"`
+	TLSInsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify"`
+}
+
+// TLSConfig controls the proxy's own listener: plain HTTP, or an
+// autocert-backed HTTPS listener with an HTTP->HTTPS redirect.
+type TLSConfig struct {
+	AutocertEnabled bool     `yaml:"autocert_enabled"`
+	Domains         []string `yaml:"domains"`
+	CacheDir        string   `yaml:"cache_dir"`
+	HTTPAddr        string   `yaml:"http_addr"`
+	HTTPSAddr       string   `yaml:"https_addr"`
+}
+
+// RouteConfig is a single routing rule: match incoming requests by host,
+// path prefix and/or method, and send them at a specific upstream or group.
+type RouteConfig struct {
+	Host        string `yaml:"host"`
+	PathPrefix  string `yaml:"path_prefix"`
+	Method      string `yaml:"method"`
+	Upstream    string `yaml:"upstream"`
+	RewriteHost string `yaml:"rewrite_host"`
+	StripPrefix string `yaml:"strip_prefix"`
+}
+
+// APIKeyConfig is one entry in the proxy's unified key namespace: a client
+// bearer token, the routes/models it may use, and the credential to swap
+// it for when talking to the upstream.
+type APIKeyConfig struct {
+	Key                string   `yaml:"key"`
+	AllowedModels      []string `yaml:"allowed_models"`
+	AllowedRoutes      []string `yaml:"allowed_routes"`
+	UpstreamCredential string   `yaml:"upstream_credential"`
+}
+
+// RateLimitConfig configures the token-bucket rate limiter applied per API
+// key and per source IP.
+type RateLimitConfig struct {
+	PerKeyRPS   float64 `yaml:"per_key_rps"`
+	PerKeyBurst int     `yaml:"per_key_burst"`
+	PerIPRPS    float64 `yaml:"per_ip_rps"`
+	PerIPBurst  int     `yaml:"per_ip_burst"`
+}
+
+// CORSConfig configures the CORS middleware.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Config is the top-level shape of config.yml.
+type Config struct {
+	ListenAddr       string           `yaml:"listen_addr"`
+	Strategy         string           `yaml:"strategy"`
+	CheckInterval    string           `yaml:"check_interval"`
+	ProxyCheckers    int              `yaml:"proxy_checkers"`
+	BreakerThreshold int              `yaml:"breaker_threshold"`
+	BreakerCooldown  string           `yaml:"breaker_cooldown"`
+	Upstreams        []UpstreamConfig `yaml:"upstreams"`
+	Routes           []RouteConfig    `yaml:"routes"`
+	TLS              TLSConfig        `yaml:"tls"`
+	APIKeys          []APIKeyConfig   `yaml:"api_keys"`
+	RateLimit        RateLimitConfig  `yaml:"rate_limit"`
+	CORS             CORSConfig       `yaml:"cors"`
+}
+
+// LoadConfig reads and parses the YAML config at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = fmt.Sprintf(":%s", os.Getenv("LISTEN_ADDR"))
+	}
+	if cfg.Strategy == "" {
+		cfg.Strategy = "primary-with-fallbacks"
+	}
+	if cfg.ProxyCheckers <= 0 {
+		cfg.ProxyCheckers = 2
+	}
+	if cfg.CheckInterval == "" {
+		cfg.CheckInterval = "5s"
+	}
+	if cfg.TLS.HTTPAddr == "" {
+		cfg.TLS.HTTPAddr = ":80"
+	}
+	if cfg.TLS.HTTPSAddr == "" {
+		cfg.TLS.HTTPSAddr = ":443"
+	}
+	if cfg.TLS.CacheDir == "" {
+		cfg.TLS.CacheDir = "autocert-cache"
+	}
+	if len(cfg.Upstreams) == 0 {
+		return nil, fmt.Errorf("config has no upstreams")
+	}
+
+	return &cfg, nil
+}
+
+// checkIntervalDuration parses CheckInterval, falling back to the package
+// default on error.
+func (c *Config) checkIntervalDuration() time.Duration {
+	d, err := time.ParseDuration(c.CheckInterval)
+	if err != nil {
+		return defaultCheckInterval
+	}
+	return d
+}
+
+// breakerCooldownDuration parses BreakerCooldown, falling back to the
+// CircuitBreaker package default on error or when unset.
+func (c *Config) breakerCooldownDuration() time.Duration {
+	if c.BreakerCooldown == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.BreakerCooldown)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// upstreamTimeout parses an UpstreamConfig's Timeout, falling back to a
+// sane default when unset or invalid.
+func (u *UpstreamConfig) upstreamTimeout() time.Duration {
+	if u.Timeout == "" {
+		return 30 * time.Second
+	}
+	d, err := time.ParseDuration(u.Timeout)
+	if err != nil {
+		return 30 * time.Second
+	}
+	return d
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HealthChecker periodically probes every upstream in a Pool using a fixed
+// number of worker goroutines, recording latency, advertised models, and
+// ejecting/reinstating upstreams via their circuit breaker as they fail or
+// recover.
+type HealthChecker struct {
+	pool        *Pool
+	interval    time.Duration
+	concurrency int
+	client      *http.Client
+}
+
+// NewHealthChecker builds a HealthChecker for pool, probing every interval
+// with concurrency workers.
+func NewHealthChecker(pool *Pool, interval time.Duration, concurrency int) *HealthChecker {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &HealthChecker{
+		pool:        pool,
+		interval:    interval,
+		concurrency: concurrency,
+		client:      &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// Run probes the pool on a ticker until stop is closed.
+func (h *HealthChecker) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	h.checkAll()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.checkAll()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkAll probes every upstream in the pool, fanning work out across
+// h.concurrency workers.
+func (h *HealthChecker) checkAll() {
+	jobs := make(chan *Upstream)
+
+	var workers int
+	if workers = h.concurrency; workers > len(h.pool.upstreams) {
+		workers = len(h.pool.upstreams)
+	}
+	if workers == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go func() {
+			for u := range jobs {
+				h.check(u)
+			}
+			done <- struct{}{}
+		}()
+	}
+
+	for _, u := range h.pool.upstreams {
+		jobs <- u
+	}
+	close(jobs)
+
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+}
+
+// tagsResponse mirrors the relevant slice of Ollama's GET /api/tags body.
+type tagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+func (h *HealthChecker) check(u *Upstream) {
+	if !u.breaker.Allow() {
+		return
+	}
+
+	start := time.Now()
+	models, err := h.fetchTags(u.URL)
+	latency := time.Since(start)
+
+	success := err == nil
+	wasHealthy := u.Healthy()
+	u.setHealth(success, latency)
+
+	if success {
+		u.setModels(models)
+		if !wasHealthy {
+			log.Printf("upstream %s is healthy again (%s), serving %d models", u.Name, latency, len(models))
+		}
+	} else if wasHealthy {
+		log.Printf("upstream %s is now unhealthy: %v", u.Name, err)
+	}
+
+	u.breaker.RecordResult(success, u.ConsecutiveFails())
+	if state := u.breaker.State(); state != "closed" {
+		log.Printf("upstream %s circuit breaker is %s", u.Name, state)
+	}
+}
+
+// fetchTags issues GET /api/tags against target and returns the set of
+// model names it advertises.
+func (h *HealthChecker) fetchTags(target *url.URL) (map[string]bool, error) {
+	tagsURL := *target
+	tagsURL.Path = "/api/tags"
+
+	resp, err := h.client.Get(tagsURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &unexpectedStatusError{status: resp.StatusCode}
+	}
+
+	var tags tagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+
+	models := make(map[string]bool, len(tags.Models))
+	for _, m := range tags.Models {
+		models[m.Name] = true
+	}
+	return models, nil
+}
+
+type unexpectedStatusError struct {
+	status int
+}
+
+func (e *unexpectedStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.status, http.StatusText(e.status))
+}
@@ -1,144 +1,198 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
 	"log"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
-	"sync"
-	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 )
 
-var (
-	listenAddr    = fmt.Sprintf(":%s", os.Getenv("LISTEN_ADDR"))
-	serverAAddr   = os.Getenv("SERVER_A_ADDR")
-	serverBAddr   = os.Getenv("SERVER_B_ADDR")
-	checkInterval = 5 * time.Second
-)
+var configPath = envOrDefault("CONFIG_PATH", "config.yml")
 
-type ServerStatus struct {
-	serverAOnline bool
-	mu            sync.RWMutex
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
 }
 
 func main() {
-	// Works with tailscale, woot woot
-	serverA, err := url.Parse(serverAAddr)
+	cfg, err := LoadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Failed to parse server A URL: %v", err)
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	serverB, err := url.Parse(serverBAddr)
+	pool, err := NewPool(cfg)
 	if err != nil {
-		log.Fatalf("Failed to parse server B URL: %v", err)
+		log.Fatalf("Failed to build upstream pool: %v", err)
 	}
 
-	proxyA := httputil.NewSingleHostReverseProxy(serverA)
-	proxyB := httputil.NewSingleHostReverseProxy(serverB)
-
-	configureWebsocketProxy(proxyA)
-	configureWebsocketProxy(proxyB)
-
-	status := &ServerStatus{serverAOnline: true}
+	router := NewRouter(cfg)
 
-	go healthChecker(status, serverA)
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		status.mu.RLock()
-		serverAIsOnline := status.serverAOnline
-		status.mu.RUnlock()
+	checker := NewHealthChecker(pool, cfg.checkIntervalDuration(), cfg.ProxyCheckers)
+	go checker.Run(nil)
 
+	apiKeys := make(map[string]*APIKeyConfig, len(cfg.APIKeys))
+	for i := range cfg.APIKeys {
+		apiKeys[cfg.APIKeys[i].Key] = &cfg.APIKeys[i]
+	}
+	rateLimiter := NewRateLimiter(cfg.RateLimit)
+
+	// Every handler, including the admin/status endpoints, goes through the
+	// same chain - an unauthenticated caller must not be able to read
+	// upstream health or rewrite the live routing table just because it
+	// isn't "/".
+	chain := Chain(
+		LoggingMiddleware(),
+		CORSMiddleware(cfg.CORS.AllowedOrigins),
+		AuthMiddleware(apiKeys),
+		rateLimiter.Middleware(),
+	)
+
+	http.Handle("/admin/routes", chain(adminRoutesHandler(router)))
+	http.Handle("/proxy/status", chain(proxyStatusHandler(pool)))
+
+	root := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Received request: %s %s", r.Method, r.URL.Path)
-		log.Printf("Server A online: %v", serverAIsOnline)
-
-		if serverAIsOnline {
-			log.Printf("Proxying to server A: %s", serverAAddr)
-			proxyA.ServeHTTP(w, r)
-		} else {
-			log.Printf("Proxying to server B: %s", serverBAddr)
-			proxyB.ServeHTTP(w, r)
-		}
+		serveRequest(pool, router, w, r)
 	})
+	http.Handle("/", chain(root))
 
-	log.Printf("Starting reverse proxy on %s", listenAddr)
-	log.Printf("Primary target: %s", serverAAddr)
-	log.Printf("Fallback target: %s", serverBAddr)
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
+	for _, u := range pool.Upstreams() {
+		log.Printf("Upstream %q: %s (weight %d)", u.Name, u.URL, u.Weight)
+	}
+	if err := serve(cfg, nil); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 }
 
-func configureWebsocketProxy(proxy *httputil.ReverseProxy) {
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
+// serveRequest routes r through router (if any rule matches) to restrict
+// candidate upstreams, then proxies it via the pool, retrying the next
+// healthy peer if the chosen one fails before writing any response.
+func serveRequest(pool *Pool, router *Router, w http.ResponseWriter, r *http.Request) {
+	// Read the model before any route rewrites r.URL.Path - modelAwarePaths
+	// is keyed on the client-facing path, so this must see what the client
+	// actually requested, not a rewritten/stripped path that happens to
+	// collide with (or divert from) one of those paths.
+	model := requestedModel(r)
 
-		if isWebSocketRequest(req) {
-			// Ensure necessary headers are forwarded
-			if req.Header.Get("Connection") != "" {
-				req.Header.Set("Connection", "Upgrade")
-			}
-			if req.Header.Get("Upgrade") != "" {
-				req.Header.Set("Upgrade", "websocket")
-			}
+	candidates := pool.Upstreams()
+
+	if rt, ok := router.Match(r); ok {
+		matched, err := routeCandidates(pool, rt)
+		if err != nil {
+			log.Printf("Route matched for %s %s but %v", r.Method, r.URL.Path, err)
+			http.Error(w, "misconfigured route", http.StatusBadGateway)
+			return
 		}
+		applyRoute(rt, r)
+		candidates = matched
 	}
 
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		if resp.StatusCode == http.StatusSwitchingProtocols {
-			log.Println("WebSocket connection established")
+	if model != "" {
+		if !modelAllowed(r, model) {
+			http.Error(w, "model not permitted for this API key", http.StatusForbidden)
+			return
 		}
-		return nil
+		candidates = filterByModel(candidates, model)
 	}
-}
-
-func isWebSocketRequest(req *http.Request) bool {
-	return req.Header.Get("Upgrade") == "websocket" &&
-		req.Header.Get("Connection") == "Upgrade"
-}
+	requestMetaFrom(r).Model = model
 
-func healthChecker(status *ServerStatus, serverA *url.URL) {
-	client := &http.Client{
-		Timeout: 2 * time.Second,
-	}
+	websocket := isWebSocketRequest(r)
+	tried := make(map[string]bool)
 
 	for {
-		req, err := http.NewRequest("HEAD", serverA.String(), nil)
+		upstream, err := pool.NextFrom(candidates, r, tried)
 		if err != nil {
-			log.Printf("Error creating health check request: %v", err)
-			setServerAStatus(status, false)
-			time.Sleep(checkInterval)
+			log.Printf("No upstream available for %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "no healthy upstream available", http.StatusBadGateway)
+			return
+		}
+		requestMetaFrom(r).Upstream = upstream.Name
+
+		if websocket {
+			log.Printf("Proxying websocket upgrade %s to upstream %q", r.URL.Path, upstream.Name)
+			if err := upstream.TryServeWebSocket(w, r); err != nil {
+				log.Printf("Upstream %q failed websocket upgrade for %s: %v, trying next peer", upstream.Name, r.URL.Path, err)
+				tried[upstream.Name] = true
+				continue
+			}
+			return
+		}
+
+		log.Printf("Proxying %s %s to upstream %q", r.Method, r.URL.Path, upstream.Name)
+		if err := upstream.TryServe(w, r); err != nil {
+			log.Printf("Upstream %q failed for %s %s: %v, trying next peer", upstream.Name, r.Method, r.URL.Path, err)
+			tried[upstream.Name] = true
 			continue
 		}
+		return
+	}
+}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Health check failed for server A: %v", err)
-			setServerAStatus(status, false)
-		} else {
-			resp.Body.Close()
-			log.Printf("Server A is online (status code: %d)", resp.StatusCode)
-			setServerAStatus(status, true)
+// upstreamStatus is the /proxy/status shape for a single upstream.
+type upstreamStatus struct {
+	Name             string   `json:"name"`
+	Healthy          bool     `json:"healthy"`
+	Breaker          string   `json:"breaker"`
+	LatencyMillis    int64    `json:"latency_ms"`
+	ConsecutiveFails int      `json:"consecutive_fails"`
+	Models           []string `json:"models"`
+}
+
+// proxyStatusHandler reports each upstream's health, breaker state, last
+// check latency and advertised models.
+func proxyStatusHandler(pool *Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]upstreamStatus, 0, len(pool.Upstreams()))
+		for _, u := range pool.Upstreams() {
+			statuses = append(statuses, upstreamStatus{
+				Name:             u.Name,
+				Healthy:          u.Healthy(),
+				Breaker:          u.breaker.State(),
+				LatencyMillis:    u.Latency().Milliseconds(),
+				ConsecutiveFails: u.ConsecutiveFails(),
+				Models:           u.Models(),
+			})
 		}
 
-		time.Sleep(checkInterval)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
 	}
 }
 
-func setServerAStatus(status *ServerStatus, online bool) {
-	status.mu.Lock()
-	defer status.mu.Unlock()
+// adminRoutesHandler exposes runtime route registration: POST adds a route,
+// DELETE removes every route pointing at the given "upstream" query value.
+func adminRoutesHandler(router *Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var rt Route
+			if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+				http.Error(w, "invalid route body", http.StatusBadRequest)
+				return
+			}
+			if rt.Upstream == "" {
+				http.Error(w, "route must name an upstream", http.StatusBadRequest)
+				return
+			}
+			router.Register(&rt)
+			w.WriteHeader(http.StatusCreated)
+
+		case http.MethodDelete:
+			upstream := r.URL.Query().Get("upstream")
+			if upstream == "" {
+				http.Error(w, "missing upstream query parameter", http.StatusBadRequest)
+				return
+			}
+			removed := router.Unregister(upstream)
+			json.NewEncoder(w).Encode(map[string]int{"removed": removed})
 
-	if status.serverAOnline != online {
-		if online {
-			log.Println("Server A is now online. Switching back to server A.")
-		} else {
-			log.Println("Server A is offline. Switching to server B.")
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
-		status.serverAOnline = online
 	}
 }
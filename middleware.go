@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Middleware wraps an http.Handler to add cross-cutting behavior in front
+// of the proxy's root handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middlewares so the first one listed runs outermost - it
+// sees the request first and the response last.
+func Chain(mws ...Middleware) Middleware {
+	return func(final http.Handler) http.Handler {
+		h := final
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
+
+type requestMetaKey struct{}
+
+// requestMeta carries fields filled in while routing/proxying a request
+// that LoggingMiddleware reports once the handler chain returns.
+type requestMeta struct {
+	Upstream string
+	Model    string
+}
+
+// withRequestMeta attaches an empty requestMeta to r's context, returning
+// both the new request and a pointer downstream handlers can fill in.
+func withRequestMeta(r *http.Request) (*http.Request, *requestMeta) {
+	meta := &requestMeta{}
+	return r.WithContext(context.WithValue(r.Context(), requestMetaKey{}, meta)), meta
+}
+
+// requestMetaFrom returns the requestMeta attached by LoggingMiddleware, or
+// a throwaway empty one if logging isn't in the chain.
+func requestMetaFrom(r *http.Request) *requestMeta {
+	if meta, ok := r.Context().Value(requestMetaKey{}).(*requestMeta); ok {
+		return meta
+	}
+	return &requestMeta{}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(auth, prefix)
+}
+
+type apiKeyContextKey struct{}
+
+// apiKeyFrom returns the APIKeyConfig AuthMiddleware matched for r, if any.
+func apiKeyFrom(r *http.Request) *APIKeyConfig {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*APIKeyConfig)
+	return key
+}
+
+// clientKey returns the bearer token the client authenticated with, even
+// after AuthMiddleware has swapped the Authorization header for an
+// upstream credential - rate limiting (and anything else downstream that
+// cares who the caller is, not who the proxy is about to pretend to be)
+// must key on this, not on bearerToken(r).
+func clientKey(r *http.Request) string {
+	if key := apiKeyFrom(r); key != nil {
+		return key.Key
+	}
+	return bearerToken(r)
+}
+
+// AuthMiddleware checks the client's OpenAI-style "Authorization: Bearer
+// sk-..." against the configured key namespace, enforces each key's route
+// allowlist, and - when the key has an UpstreamCredential - swaps the
+// header for it so the Director forwards an upstream-specific credential
+// instead of the client's key. If no keys are configured, auth is a no-op.
+func AuthMiddleware(keys map[string]*APIKeyConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(keys) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := bearerToken(r)
+			key, ok := keys[token]
+			if !ok {
+				http.Error(w, "invalid or missing API key", http.StatusUnauthorized)
+				return
+			}
+
+			if len(key.AllowedRoutes) > 0 && !routeAllowed(key.AllowedRoutes, r.URL.Path) {
+				http.Error(w, "route not permitted for this API key", http.StatusForbidden)
+				return
+			}
+
+			if key.UpstreamCredential != "" {
+				r.Header.Set("Authorization", "Bearer "+key.UpstreamCredential)
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+		})
+	}
+}
+
+func routeAllowed(allowed []string, path string) bool {
+	for _, prefix := range allowed {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// modelAllowed reports whether r's matched API key (if any) is permitted to
+// use model. A request with no matched key, or a key with no model
+// allowlist, is always permitted.
+func modelAllowed(r *http.Request, model string) bool {
+	key := apiKeyFrom(r)
+	if key == nil || len(key.AllowedModels) == 0 || model == "" {
+		return true
+	}
+	for _, m := range key.AllowedModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimiter enforces independent token-bucket limits per API key and per
+// source IP, creating each bucket lazily on first use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	perKey   map[string]*rate.Limiter
+	perIP    map[string]*rate.Limiter
+	keyLimit rate.Limit
+	keyBurst int
+	ipLimit  rate.Limit
+	ipBurst  int
+}
+
+// NewRateLimiter builds a RateLimiter from config. A zero RPS disables
+// limiting along that dimension.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		perKey:   make(map[string]*rate.Limiter),
+		perIP:    make(map[string]*rate.Limiter),
+		keyLimit: rate.Limit(cfg.PerKeyRPS),
+		keyBurst: cfg.PerKeyBurst,
+		ipLimit:  rate.Limit(cfg.PerIPRPS),
+		ipBurst:  cfg.PerIPBurst,
+	}
+}
+
+func (rl *RateLimiter) allow(bucket map[string]*rate.Limiter, id string, limit rate.Limit, burst int) bool {
+	rl.mu.Lock()
+	limiter, ok := bucket[id]
+	if !ok {
+		limiter = rate.NewLimiter(limit, burst)
+		bucket[id] = limiter
+	}
+	rl.mu.Unlock()
+
+	return limiter.Allow()
+}
+
+// Middleware rejects requests that exceed either the per-key or per-IP
+// limit with 429 Too Many Requests.
+func (rl *RateLimiter) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rl.ipLimit > 0 {
+				if !rl.allow(rl.perIP, sourceIP(r), rl.ipLimit, rl.ipBurst) {
+					http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			if rl.keyLimit > 0 {
+				if key := clientKey(r); key != "" && !rl.allow(rl.perKey, key, rl.keyLimit, rl.keyBurst) {
+					http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loggingResponseWriter captures the status and byte count LoggingMiddleware
+// reports, and passes hijacking through unchanged so it doesn't break the
+// websocket proxy path.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("logging middleware: underlying response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// LoggingMiddleware records a structured audit line per request: method,
+// path, upstream chosen, status, bytes written, duration, and model name
+// when one was extracted from the body.
+func LoggingMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, meta := withRequestMeta(r)
+			lrw := &loggingResponseWriter{ResponseWriter: w}
+
+			next.ServeHTTP(lrw, r)
+
+			log.Printf("audit method=%s path=%s upstream=%s model=%s status=%d bytes=%d duration=%s",
+				r.Method, r.URL.Path, meta.Upstream, meta.Model, lrw.status, lrw.bytes, time.Since(start))
+		})
+	}
+}
+
+// CORSMiddleware reflects an allowed Origin back in the response headers
+// and short-circuits preflight OPTIONS requests.
+func CORSMiddleware(allowedOrigins []string) Middleware {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	allowAll := false
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowAll || allowed[origin]) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			}
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
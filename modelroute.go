@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// modelAwarePaths lists the request paths whose JSON body carries a
+// "model" field that should constrain which upstreams are eligible.
+var modelAwarePaths = map[string]bool{
+	"/api/generate":        true,
+	"/api/chat":            true,
+	"/v1/chat/completions": true,
+}
+
+type modelRequestBody struct {
+	Model string `json:"model"`
+}
+
+// maxModelSniffBytes caps how much of a request body requestedModel buffers
+// to find the "model" field. Chat/generate payloads can carry multi-MB
+// base64 images or long contexts, and the model field is always near the
+// front of the JSON object, so there's no reason to buffer more than this
+// just to sniff it; a body that doesn't yield a model within the limit is
+// treated as "model unknown" rather than read in full.
+const maxModelSniffBytes = 64 * 1024
+
+// sniffedBody reassembles a body we've partially read (head) with what's
+// left of the original reader, so the proxy still forwards the request
+// untouched regardless of how much requestedModel needed to look at.
+type sniffedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// requestedModel reads the "model" field out of r's JSON body, if r's path
+// is one we route by model. The body is restored onto r afterwards so the
+// proxy can still forward it untouched.
+func requestedModel(r *http.Request) string {
+	if !modelAwarePaths[r.URL.Path] || r.Body == nil {
+		return ""
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r.Body, maxModelSniffBytes))
+	r.Body = sniffedBody{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+
+	if err != nil {
+		return ""
+	}
+
+	var parsed modelRequestBody
+	if err := json.Unmarshal(head, &parsed); err != nil {
+		return ""
+	}
+	return parsed.Model
+}
+
+// filterByModel narrows candidates down to the ones that advertise model.
+// If none do, the original candidates are returned unfiltered rather than
+// failing the request outright - an upstream that hasn't reported its
+// model list yet is better than none.
+func filterByModel(candidates []*Upstream, model string) []*Upstream {
+	if model == "" {
+		return candidates
+	}
+
+	filtered := make([]*Upstream, 0, len(candidates))
+	for _, u := range candidates {
+		if u.Serves(model) {
+			filtered = append(filtered, u)
+		}
+	}
+
+	if len(filtered) == 0 {
+		return candidates
+	}
+	return filtered
+}
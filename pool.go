@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type proxyErrContextKey struct{}
+
+// withProxyErr attaches an *error to req's context that the upstream's
+// ErrorHandler will populate instead of writing a response directly,
+// letting the caller decide whether to retry another upstream.
+func withProxyErr(req *http.Request, errOut *error) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), proxyErrContextKey{}, errOut))
+}
+
+// Upstream is a single backend in the pool, along with the runtime health
+// state the checker maintains for it.
+type Upstream struct {
+	Name      string
+	Group     string
+	URL       *url.URL
+	Weight    int
+	Proxy     *httputil.ReverseProxy
+	TLSConfig *tls.Config
+
+	breaker *CircuitBreaker
+
+	mu               sync.RWMutex
+	healthy          bool
+	consecutiveFails int
+	latency          time.Duration
+	models           map[string]bool
+
+	activeConns int64
+}
+
+func (u *Upstream) setHealth(healthy bool, latency time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.latency = latency
+	if healthy {
+		u.consecutiveFails = 0
+	} else {
+		u.consecutiveFails++
+	}
+	u.healthy = healthy
+}
+
+// Healthy reports whether the upstream is currently eligible for traffic.
+func (u *Upstream) Healthy() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.healthy
+}
+
+func (u *Upstream) Latency() time.Duration {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.latency
+}
+
+func (u *Upstream) ConsecutiveFails() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.consecutiveFails
+}
+
+// setModels replaces the set of models this upstream is known to serve, as
+// last reported by GET /api/tags.
+func (u *Upstream) setModels(models map[string]bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.models = models
+}
+
+// Models returns the names of every model this upstream last advertised.
+func (u *Upstream) Models() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	names := make([]string, 0, len(u.models))
+	for name := range u.models {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Serves reports whether this upstream last advertised the given model.
+// An upstream that hasn't reported any models yet (e.g. before the first
+// successful health check) is assumed to serve everything, so routing
+// doesn't wedge on startup.
+func (u *Upstream) Serves(model string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	if len(u.models) == 0 {
+		return true
+	}
+	return u.models[model]
+}
+
+// Strategy selects one healthy upstream from the pool for a given request.
+type Strategy interface {
+	Select(healthy []*Upstream, r *http.Request) (*Upstream, error)
+}
+
+// Pool wraps a set of upstreams and a selection Strategy, and tracks which
+// upstreams are currently healthy.
+type Pool struct {
+	upstreams []*Upstream
+	strategy  Strategy
+}
+
+// NewPool builds a Pool from config, constructing a ReverseProxy for each
+// upstream.
+func NewPool(cfg *Config) (*Pool, error) {
+	p := &Pool{}
+
+	for _, uc := range cfg.Upstreams {
+		target, err := url.Parse(uc.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing upstream %q url: %w", uc.Name, err)
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		// Flush every write immediately instead of buffering, so NDJSON
+		// (Ollama) and SSE (OpenAI-compatible) streaming responses reach
+		// the client token-by-token rather than in one batch at the end.
+		proxy.FlushInterval = -1
+		proxy.ErrorHandler = proxyErrorHandler
+
+		tlsConfig, err := upstreamTLSConfig(&uc)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q tls config: %w", uc.Name, err)
+		}
+		// ResponseHeaderTimeout bounds how long we wait for the upstream to
+		// start responding; it doesn't cut off an in-flight streamed body,
+		// so it's safe for the NDJSON/SSE responses this proxy forwards.
+		proxy.Transport = &http.Transport{
+			TLSClientConfig:       tlsConfig,
+			ResponseHeaderTimeout: uc.upstreamTimeout(),
+		}
+
+		weight := uc.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		p.upstreams = append(p.upstreams, &Upstream{
+			Name:      uc.Name,
+			Group:     uc.Group,
+			URL:       target,
+			Weight:    weight,
+			Proxy:     proxy,
+			TLSConfig: tlsConfig,
+			healthy:   true,
+			breaker:   NewCircuitBreaker(cfg.BreakerThreshold, cfg.breakerCooldownDuration()),
+		})
+	}
+
+	strategy, err := newStrategy(cfg.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	p.strategy = strategy
+
+	return p, nil
+}
+
+func newStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", "primary-with-fallbacks":
+		return &primaryWithFallbacksStrategy{}, nil
+	case "round-robin":
+		return &roundRobinStrategy{}, nil
+	case "weighted-random":
+		return &weightedRandomStrategy{}, nil
+	case "least-connections":
+		return &leastConnectionsStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+}
+
+// Upstreams returns every upstream in the pool, healthy or not.
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// ByName returns the upstream with the given name, or nil if none matches.
+func (p *Pool) ByName(name string) *Upstream {
+	for _, u := range p.upstreams {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// Group returns every upstream belonging to the named group.
+func (p *Pool) Group(name string) []*Upstream {
+	var group []*Upstream
+	for _, u := range p.upstreams {
+		if u.Group == name {
+			group = append(group, u)
+		}
+	}
+	return group
+}
+
+func healthyOf(upstreams []*Upstream) []*Upstream {
+	healthy := make([]*Upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if u.Healthy() {
+			healthy = append(healthy, u)
+		}
+	}
+	return healthy
+}
+
+// Next selects the next upstream to try for r out of the whole pool,
+// excluding any upstreams already present in tried.
+func (p *Pool) Next(r *http.Request, tried map[string]bool) (*Upstream, error) {
+	return p.NextFrom(p.upstreams, r, tried)
+}
+
+// NextFrom selects the next upstream to try for r out of candidates,
+// excluding any upstreams already present in tried. It's used by the router
+// to restrict selection to the upstream(s) a rule names.
+func (p *Pool) NextFrom(candidates []*Upstream, r *http.Request, tried map[string]bool) (*Upstream, error) {
+	healthy := healthyOf(candidates)
+	if tried != nil {
+		filtered := healthy[:0]
+		for _, u := range healthy {
+			if !tried[u.Name] {
+				filtered = append(filtered, u)
+			}
+		}
+		healthy = filtered
+	}
+
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("no healthy upstreams available")
+	}
+
+	return p.strategy.Select(healthy, r)
+}
+
+// primaryWithFallbacksStrategy always prefers the first healthy upstream in
+// pool order, preserving the original serverA/serverB behavior.
+type primaryWithFallbacksStrategy struct{}
+
+func (s *primaryWithFallbacksStrategy) Select(healthy []*Upstream, r *http.Request) (*Upstream, error) {
+	return healthy[0], nil
+}
+
+// roundRobinStrategy cycles through healthy upstreams in order.
+type roundRobinStrategy struct {
+	counter uint64
+}
+
+func (s *roundRobinStrategy) Select(healthy []*Upstream, r *http.Request) (*Upstream, error) {
+	idx := atomic.AddUint64(&s.counter, 1)
+	return healthy[int(idx)%len(healthy)], nil
+}
+
+// weightedRandomStrategy picks a healthy upstream at random, weighted by
+// each upstream's configured weight.
+type weightedRandomStrategy struct{}
+
+func (s *weightedRandomStrategy) Select(healthy []*Upstream, r *http.Request) (*Upstream, error) {
+	total := 0
+	for _, u := range healthy {
+		total += u.Weight
+	}
+
+	pick := rand.Intn(total)
+	for _, u := range healthy {
+		pick -= u.Weight
+		if pick < 0 {
+			return u, nil
+		}
+	}
+
+	return healthy[len(healthy)-1], nil
+}
+
+// leastConnectionsStrategy picks the healthy upstream with the fewest
+// in-flight requests.
+type leastConnectionsStrategy struct{}
+
+func (s *leastConnectionsStrategy) Select(healthy []*Upstream, r *http.Request) (*Upstream, error) {
+	best := healthy[0]
+	for _, u := range healthy[1:] {
+		if atomic.LoadInt64(&u.activeConns) < atomic.LoadInt64(&best.activeConns) {
+			best = u
+		}
+	}
+	return best, nil
+}
+
+// proxyErrorHandler records the proxy error on the request context set up by
+// withProxyErr, instead of writing a response itself, so the caller can
+// retry a different upstream. If no error pointer is present it falls back
+// to httputil's default behavior.
+func proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errOut, ok := r.Context().Value(proxyErrContextKey{}).(*error); ok {
+		*errOut = err
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// TryServe attempts to proxy r/w through u, tracking the in-flight
+// connection count used by leastConnectionsStrategy. It returns a non-nil
+// error, without having written anything to w, if the upstream failed
+// before any response bytes were sent - in which case the caller may retry
+// another upstream.
+func (u *Upstream) TryServe(w http.ResponseWriter, r *http.Request) error {
+	atomic.AddInt64(&u.activeConns, 1)
+	defer atomic.AddInt64(&u.activeConns, -1)
+
+	var proxyErr error
+	rw := &retryResponseWriter{ResponseWriter: w}
+	u.Proxy.ServeHTTP(rw, withProxyErr(r, &proxyErr))
+
+	if proxyErr != nil && !rw.written {
+		return proxyErr
+	}
+	return nil
+}
+
+// TryServeWebSocket hijacks w/r and splices the connection to u, tracking
+// the in-flight connection count. Unlike TryServe, once the handshake has
+// been forwarded the connection can no longer fall back to another
+// upstream, so callers should only retry on the error returned here (which
+// is only possible before the client connection is hijacked).
+func (u *Upstream) TryServeWebSocket(w http.ResponseWriter, r *http.Request) error {
+	atomic.AddInt64(&u.activeConns, 1)
+	defer atomic.AddInt64(&u.activeConns, -1)
+
+	return proxyWebSocket(w, r, u.URL, u.TLSConfig)
+}
+
+// retryResponseWriter tracks whether anything has been written to the
+// underlying ResponseWriter yet, so a failed proxy attempt can be retried
+// against another upstream as long as no bytes have reached the client.
+type retryResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *retryResponseWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *retryResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Route matches incoming requests against a host pattern, path prefix and
+// method, and directs matches at a named upstream or group. RewriteHost and
+// StripPrefix, when set, are applied in the Director before the request
+// reaches the upstream.
+type Route struct {
+	Host        string
+	PathPrefix  string
+	Method      string
+	Upstream    string
+	RewriteHost string
+	StripPrefix string
+}
+
+// Matches reports whether r satisfies every non-empty field of the route.
+func (rt *Route) Matches(r *http.Request) bool {
+	if rt.Host != "" && !hostMatches(rt.Host, r.Host) {
+		return false
+	}
+	if rt.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.PathPrefix) {
+		return false
+	}
+	if rt.Method != "" && !strings.EqualFold(rt.Method, r.Method) {
+		return false
+	}
+	return true
+}
+
+// hostMatches supports a leading "*." glob (e.g. "*.example.com") in
+// addition to an exact match; reqHost may carry a ":port" suffix which is
+// stripped before comparing.
+func hostMatches(pattern, reqHost string) bool {
+	if idx := strings.IndexByte(reqHost, ':'); idx != -1 {
+		reqHost = reqHost[:idx]
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:] // ".example.com"
+		return strings.HasSuffix(reqHost, suffix) && reqHost != suffix[1:]
+	}
+
+	return pattern == reqHost
+}
+
+// Router holds the set of routing rules and matches requests against them
+// in registration order, first match wins. Rules can be registered and
+// unregistered at runtime, guarded by mu.
+type Router struct {
+	mu     sync.RWMutex
+	routes []*Route
+}
+
+// NewRouter builds a Router from the routes declared in config.
+func NewRouter(cfg *Config) *Router {
+	router := &Router{}
+	for _, rc := range cfg.Routes {
+		router.Register(&Route{
+			Host:        rc.Host,
+			PathPrefix:  rc.PathPrefix,
+			Method:      rc.Method,
+			Upstream:    rc.Upstream,
+			RewriteHost: rc.RewriteHost,
+			StripPrefix: rc.StripPrefix,
+		})
+	}
+	return router
+}
+
+// Register adds a route, evaluated after any existing routes.
+func (router *Router) Register(rt *Route) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.routes = append(router.routes, rt)
+}
+
+// Unregister removes every route pointing at the given upstream/group name.
+// It returns the number of routes removed.
+func (router *Router) Unregister(upstream string) int {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	kept := router.routes[:0]
+	removed := 0
+	for _, rt := range router.routes {
+		if rt.Upstream == upstream {
+			removed++
+			continue
+		}
+		kept = append(kept, rt)
+	}
+	router.routes = kept
+	return removed
+}
+
+// Match returns the first route whose criteria satisfy r, if any.
+func (router *Router) Match(r *http.Request) (*Route, bool) {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	for _, rt := range router.routes {
+		if rt.Matches(r) {
+			return rt, true
+		}
+	}
+	return nil, false
+}
+
+// candidates resolves a route's Upstream field (a single upstream name or a
+// group name) to the set of pool upstreams it refers to.
+func routeCandidates(pool *Pool, rt *Route) ([]*Upstream, error) {
+	if u := pool.ByName(rt.Upstream); u != nil {
+		return []*Upstream{u}, nil
+	}
+	if group := pool.Group(rt.Upstream); len(group) > 0 {
+		return group, nil
+	}
+	return nil, fmt.Errorf("route upstream %q not found in pool", rt.Upstream)
+}
+
+// applyRoute rewrites the request per rt's directives: StripPrefix trims a
+// leading path segment before it reaches the upstream, RewriteHost
+// overrides the Host header the Director would otherwise forward.
+func applyRoute(rt *Route, req *http.Request) {
+	if rt.StripPrefix != "" && strings.HasPrefix(req.URL.Path, rt.StripPrefix) {
+		trimmed := strings.TrimPrefix(req.URL.Path, rt.StripPrefix)
+		if !strings.HasPrefix(trimmed, "/") {
+			trimmed = "/" + trimmed
+		}
+		req.URL.Path = path.Clean(trimmed)
+	}
+	if rt.RewriteHost != "" {
+		req.Host = rt.RewriteHost
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// isWebSocketRequest reports whether req is an HTTP Upgrade request for a
+// websocket, per RFC 6455. Connection may be a comma-separated list (e.g.
+// "keep-alive, Upgrade"), so we look for the "upgrade" token rather than an
+// exact match.
+func isWebSocketRequest(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, token := range strings.Split(req.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyWebSocket dials target directly and splices it to the client's TCP
+// connection, rather than going through httputil.ReverseProxy, which only
+// forwards a single request/response pair and cannot carry a bidirectional
+// stream. The handshake request is replayed verbatim to the backend; from
+// then on bytes are copied in both directions until either side closes.
+// tlsConfig is used (and TLS is dialed instead of plain TCP) when target's
+// scheme is "https" or "wss"; pass nil for a plain ws:// upstream.
+func proxyWebSocket(w http.ResponseWriter, r *http.Request, target *url.URL, tlsConfig *tls.Config) error {
+	backendConn, err := dialUpstream(target, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("dialing backend: %w", err)
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		backendConn.Close()
+		return fmt.Errorf("websocket proxy: response writer does not support hijacking")
+	}
+
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		backendConn.Close()
+		return fmt.Errorf("hijacking client connection: %w", err)
+	}
+
+	if err := r.Write(backendConn); err != nil {
+		clientConn.Close()
+		backendConn.Close()
+		return fmt.Errorf("forwarding handshake to backend: %w", err)
+	}
+
+	errc := make(chan error, 2)
+	go splice(errc, backendConn, clientConn)
+	go splice(errc, clientConn, backendConn)
+	err = <-errc
+
+	clientConn.Close()
+	backendConn.Close()
+
+	return err
+}
+
+// dialUpstream opens a TCP (ws://, http://) or TLS (wss://, https://)
+// connection to target's host. tlsConfig may be nil, in which case TLS
+// dials use the default verification behavior.
+func dialUpstream(target *url.URL, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	addr := hostWithPort(target)
+
+	switch target.Scheme {
+	case "https", "wss":
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	default:
+		return dialer.Dial("tcp", addr)
+	}
+}
+
+// hostWithPort returns target.Host, defaulting the port from target.Scheme
+// (80 for ws/http, 443 for wss/https) when target.Host doesn't already
+// carry one. Unlike httputil.NewSingleHostReverseProxy's use of the default
+// http.Transport, net.Dial/tls.Dial require an explicit port.
+func hostWithPort(target *url.URL) string {
+	if _, _, err := net.SplitHostPort(target.Host); err == nil {
+		return target.Host
+	}
+
+	switch target.Scheme {
+	case "https", "wss":
+		return net.JoinHostPort(target.Host, "443")
+	default:
+		return net.JoinHostPort(target.Host, "80")
+	}
+}
+
+// splice copies src into dst and reports the first error (including a
+// plain EOF on abrupt disconnect) back on errc so the caller can tear down
+// both ends of the pipe.
+func splice(errc chan<- error, dst io.Writer, src io.Reader) {
+	_, err := io.Copy(dst, src)
+	errc <- err
+}
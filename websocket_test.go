@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+	wsOpClose = 0x8
+)
+
+// fakeWebSocketBackend accepts a single websocket handshake, replies with a
+// pong for any ping it receives, and echoes any other frame back unchanged.
+// It reports whether the connection was eventually closed by the peer.
+func fakeWebSocketBackend(t *testing.T) (addr string, closed <-chan struct{}) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening for fake backend: %v", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer ln.Close()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			close(done)
+			return
+		}
+		defer conn.Close()
+		defer close(done)
+
+		reader := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(reader); err != nil {
+			return
+		}
+
+		fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+
+		for {
+			op, payload, err := readFrame(reader)
+			if err != nil {
+				return
+			}
+			if op == wsOpClose {
+				return
+			}
+			if op == wsOpPing {
+				op = wsOpPong
+			}
+			writeFrame(conn, op, payload)
+		}
+	}()
+
+	return ln.Addr().String(), done
+}
+
+func TestWebSocketProxyPingPong(t *testing.T) {
+	backendAddr, backendClosed := fakeWebSocketBackend(t)
+	target, err := url.Parse("http://" + backendAddr)
+	if err != nil {
+		t.Fatalf("parsing backend url: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := proxyWebSocket(w, r, target, nil); err != nil {
+			t.Errorf("proxyWebSocket: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	conn, reader := dialWebSocket(t, server.URL)
+	defer conn.Close()
+
+	payload := []byte("hello")
+	writeFrame(conn, wsOpPing, payload)
+
+	op, got, err := readFrame(reader)
+	if err != nil {
+		t.Fatalf("reading pong: %v", err)
+	}
+	if op != wsOpPong {
+		t.Fatalf("expected pong opcode %d, got %d", wsOpPong, op)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("expected echoed payload %q, got %q", payload, got)
+	}
+
+	// Abruptly closing the client connection, with no close frame, is what
+	// makes a conforming browser client surface close code 1006. We can't
+	// observe that client-side constant from here, but we can assert the
+	// proxy propagates the disconnect to the backend rather than leaking
+	// the connection open.
+	conn.Close()
+
+	select {
+	case <-backendClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend connection was not closed after abrupt client disconnect")
+	}
+}
+
+func dialWebSocket(t *testing.T, serverURL string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatalf("parsing server url: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatalf("dialing proxy: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, serverURL, nil)
+	if err != nil {
+		t.Fatalf("building handshake request: %v", err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("writing handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("reading handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101 Switching Protocols, got %d", resp.StatusCode)
+	}
+
+	return conn, reader
+}
+
+// writeFrame writes a minimal, unfragmented, masked websocket frame - the
+// client-to-server direction of the protocol is always masked per RFC 6455.
+func writeFrame(w io.Writer, opcode byte, payload []byte) {
+	header := []byte{0x80 | opcode}
+
+	switch {
+	case len(payload) < 126:
+		header = append(header, 0x80|byte(len(payload)))
+	default:
+		header = append(header, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	header = append(header, mask...)
+
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	w.Write(header)
+	w.Write(masked)
+}
+
+// readFrame reads a single unfragmented frame, masked or not, and returns
+// its opcode and unmasked payload. It's sufficient for this test's small
+// control-frame exchanges, not a general-purpose websocket reader.
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode = first & 0x0F
+
+	second, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	masked := second&0x80 != 0
+	length := int(second & 0x7F)
+
+	switch length {
+	case 126:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, nil, err
+		}
+		length = int(buf[0])<<8 | int(buf[1])
+	case 127:
+		buf := make([]byte, 8)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, nil, err
+		}
+		length = 0
+		for _, b := range buf {
+			length = length<<8 | int(b)
+		}
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return opcode, payload, nil
+}